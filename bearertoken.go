@@ -3,39 +3,43 @@
 // The middleware is RFC6750-compliant, however, it does allow you to specify
 // non-compliant settings.
 //
+// This package targets Fiber v2. If you cannot upgrade yet, the v1 tag of this repository
+// still targets Fiber v1. A parallel v3 module, targeting the Fiber v3 beta, is available
+// under the v3/ subdirectory.
+//
 // Quick Start
 //
 //   package main
 //
 //   import (
-//     "github.com/gofiber/fiber"
-//     bearertoken "github.com/jozsefsallai/fiber-bearer-token"
+//     "github.com/gofiber/fiber/v2"
+//     bearertoken "github.com/jozsefsallai/fiber-bearer-token/v2"
 //   )
 //
 //   func main() {
 //     app := fiber.New()
 //     app.Use(bearertoken.New(nil))
-//     app.Listen(3000)
+//     app.Listen(":3000")
 //   }
 //
 // You can access the bearer token from the request's context using the designated local
 // variable. By default, the variable is called "token", but you can change it to anything.
 //
-//   app.Get("/", func (ctx *fiber.Ctx)) {
-//     bearer := ctx.Locals("token")
-//     if len(bearer) == 0 {
-//       ctx.Send("Unauthorized!")
-//     } else {
-//       ctx.Send("You're in!")
+//   app.Get("/", func(c *fiber.Ctx) error {
+//     bearer := c.Locals("token")
+//     if bearer == nil || bearer.(string) == "" {
+//       return c.SendString("Unauthorized!")
 //     }
+//     return c.SendString("You're in!")
 //   })
 //
-// The middleware searches for the bearer token inside the query paramters, then the body
-// parameters, and then the authorization header (if its value starts with the specified
-// key), in this order. Support for cookies is a planned feature.
+// By default, the middleware searches for the bearer token inside the query paramters, then
+// the body parameters, then the authorization header (if its value starts with the specified
+// key), and then the cookies, in this order. You can customize which sources are scanned, and
+// in what order, using the Sources option (see below).
 //
-// As per RFC6750, if the middleware finds more than one bearer tokens, it will return an
-// HTTP 400 status code, aborting the request.
+// As per RFC6750, if the middleware finds more than one bearer tokens, it will abort the
+// request with an HTTP 400 status code.
 //
 // You can customize the names of the keys, as well as the name of the local variable during
 // the initialization of the middleware:
@@ -46,14 +50,83 @@
 //     QueryKey: "auth_token",
 //     RequestKey: "bearer"
 //   }))
+//
+// For finer-grained control over which sources are scanned and in what order, use the
+// Sources option. Each entry is a "type:key" string, where type is one of "query", "form",
+// "header", "cookie", or "param":
+//
+//   app.Use(bearertoken.New(&bearertoken.Config{
+//     Sources: []string{"header:Authorization", "cookie:access_token"},
+//   }))
+//
+// If you want the middleware to validate the token itself instead of leaving that to your
+// route handlers, provide a Validator. Use crypto/subtle.ConstantTimeCompare (or an
+// equivalent constant-time check) when comparing the token against a known secret, to avoid
+// leaking timing information to an attacker:
+//
+//   app.Use(bearertoken.New(&bearertoken.Config{
+//     Validator: func(c *fiber.Ctx, token string) (bool, error) {
+//       ok := subtle.ConstantTimeCompare([]byte(token), []byte("my-secret-token")) == 1
+//       return ok, nil
+//     },
+//   }))
+//
+// Use Filter to skip the middleware entirely on specific routes:
+//
+//   app.Use(bearertoken.New(&bearertoken.Config{
+//     Filter: func(c *fiber.Ctx) bool {
+//       return c.Path() == "/health"
+//     },
+//   }))
+//
+// The Authorization header's scheme is matched case-insensitively, per RFC6750, so "Bearer",
+// "bearer", and "BEARER" are all accepted. To accept more than one scheme (e.g. both "Bearer"
+// and a custom "Token"), use HeaderKeys instead of HeaderKey. Set StrictRFC6750 to reject
+// requests that use an unrecognized or empty scheme with an HTTP 400 instead of ignoring them:
+//
+//   app.Use(bearertoken.New(&bearertoken.Config{
+//     HeaderKeys: []string{"Bearer", "Token"},
+//     StrictRFC6750: true,
+//   }))
+//
+// If the token itself is a JWT or a PASETO, set TokenType to have the middleware parse and
+// verify it for you. The parsed claims are stored in ctx.Locals under RequestKey+"_claims":
+//
+//   app.Use(bearertoken.New(&bearertoken.Config{
+//     TokenType: bearertoken.TokenTypeJWT,
+//     JWTConfig: &bearertoken.JWTConfig{
+//       SigningKey: []byte("my-secret"),
+//       SigningMethods: []string{"HS256"},
+//     },
+//   }))
+//
+// If you'd rather delegate validation to an authorization server, set Introspection to have
+// the middleware call a remote RFC7662 token introspection endpoint. Responses are cached,
+// keyed by a hash of the token, for up to five minutes and never past their "exp" claim:
+//
+//   app.Use(bearertoken.New(&bearertoken.Config{
+//     Introspection: &bearertoken.IntrospectionConfig{
+//       URL: "https://auth.example.com/oauth2/introspect",
+//       ClientID: "my-client-id",
+//       ClientSecret: "my-client-secret",
+//       RequiredScopes: []string{"read:things"},
+//     },
+//   }))
 package bearertoken
 
 import (
+	"errors"
 	"strings"
+	"time"
 
-	"github.com/gofiber/fiber"
+	"github.com/gofiber/fiber/v2"
 )
 
+// ErrMissingOrMalformedToken is passed to the ErrorHandler when a Validator is configured
+// and the request does not contain a bearer token, or the Validator rejects the token
+// without returning an error of its own.
+var ErrMissingOrMalformedToken = errors.New("missing or malformed bearer token")
+
 // Config holds the configuration of the middleware. It is completely optional
 // and should only be provided if your application uses token keys that are not
 // RFC6750-compliant.
@@ -64,28 +137,143 @@ type Config struct {
 	BodyKey string
 
 	// HeaderKey defines the prefix of the Authorization header's value, used when
-	// searching for the bearer token inside the request's headers.
+	// searching for the bearer token inside the request's headers. The match is
+	// case-insensitive, per RFC6750. For multiple accepted schemes, use HeaderKeys
+	// instead.
 	// Optional. Default: "Bearer".
 	HeaderKey string
 
+	// HeaderKeys defines a list of accepted prefixes for the Authorization header's
+	// value, for applications that need to accept more than one scheme (e.g. both
+	// "Bearer" and a custom "Token"). Matching is case-insensitive. When set, this
+	// takes precedence over HeaderKey.
+	// Optional. Default: []string{HeaderKey}.
+	HeaderKeys []string
+
+	// StrictRFC6750, when true, rejects requests whose Authorization header uses a
+	// scheme other than HeaderKey/HeaderKeys, or an empty scheme, with an HTTP 400
+	// status code instead of silently ignoring the header.
+	// Optional. Default: false.
+	StrictRFC6750 bool
+
 	// QueryKey defines the key to use when searching for the bearer token inside the
 	// request's query parameters.
 	// Optional. Default: "access_token".
 	QueryKey string
 
+	// CookieKey defines the key to use when searching for the bearer token inside the
+	// request's cookies.
+	// Optional. Default: "access_token".
+	CookieKey string
+
 	// RequestKey defines the name of the local variable that will be created in the
 	// request's context, which will contain the bearer token extracted from the
 	// request.
 	// Optional. Default: "token".
 	RequestKey string
+
+	// Sources defines which request sources are scanned for the bearer token, and in
+	// what order. Each entry is a "type:key" string, where type is one of "query",
+	// "form", "header", "cookie", or "param", and key is the name of the query
+	// parameter, body parameter, header, cookie, or route parameter to read,
+	// respectively. When a "header" entry is used, the header's value is still
+	// matched against HeaderKey/HeaderKeys to strip the scheme prefix.
+	// Optional. Default: query, form, header, and cookie, using the respective *Key
+	// config fields.
+	Sources []string
+
+	// Filter defines a function that is called before the middleware runs. If it
+	// returns true, the middleware is skipped entirely for the request.
+	// Optional. Default: nil.
+	Filter func(*fiber.Ctx) bool
+
+	// Validator defines a function that is called with the extracted bearer token.
+	// It should return true if the token is valid, along with an optional error to
+	// pass to ErrorHandler. If Validator is nil, no custom check is run; TokenType
+	// and Introspection, when set, still verify the token.
+	// Optional. Default: nil.
+	Validator func(*fiber.Ctx, string) (bool, error)
+
+	// SuccessHandler defines a function that is called when a Validator, a TokenType
+	// other than TokenTypeOpaque, or Introspection is configured and the token passes
+	// every configured check. Otherwise the middleware calls c.Next() directly.
+	// Optional. Default: calls c.Next().
+	SuccessHandler func(*fiber.Ctx) error
+
+	// ErrorHandler defines a function that is called when a Validator, a TokenType
+	// other than TokenTypeOpaque, or Introspection is configured and the token is
+	// missing or fails one of those checks. The underlying error (which may
+	// come from a JWT/PASETO parser, the introspection HTTP client, or Validator
+	// itself) is passed in for logging purposes; it is not safe to echo back to the
+	// caller as-is.
+	// Optional. Default: responds with HTTP 401 and a generic message.
+	ErrorHandler func(*fiber.Ctx, error) error
+
+	// TokenType selects how the extracted token is verified before being handed off
+	// to Validator and the route handler. When set to TokenTypeJWT or
+	// TokenTypePASETO, the parsed claims are stored in ctx.Locals under
+	// RequestKey+"_claims".
+	// Optional. Default: TokenTypeOpaque.
+	TokenType TokenType
+
+	// JWTConfig holds the settings used to verify the token when TokenType is
+	// TokenTypeJWT.
+	JWTConfig *JWTConfig
+
+	// PASETOConfig holds the settings used to verify the token when TokenType is
+	// TokenTypePASETO.
+	PASETOConfig *PASETOConfig
+
+	// Introspection, when set, validates the extracted token against a remote
+	// RFC7662 token introspection endpoint instead of (or in addition to)
+	// Validator/TokenType.
+	// Optional. Default: nil.
+	Introspection *IntrospectionConfig
+}
+
+func defaultSuccessHandler(c *fiber.Ctx) error {
+	return c.Next()
 }
 
+func defaultErrorHandler(c *fiber.Ctx, err error) error {
+	// err is deliberately not included in the response: it may carry raw parser,
+	// transport, or introspection-endpoint detail that shouldn't reach an
+	// unauthenticated caller. Provide a custom ErrorHandler to log it instead.
+	return fiber.NewError(fiber.StatusUnauthorized, "invalid token")
+}
+
+// formValue reads a body parameter, checking the url-encoded POST body and then a
+// multipart form. Unlike c.FormValue, this deliberately does not fall back to the query
+// string, so that the "form" and "query" sources don't both match the same value.
+func formValue(c *fiber.Ctx, key string) string {
+	if value := c.Context().PostArgs().Peek(key); len(value) > 0 {
+		return string(value)
+	}
+
+	if form, err := c.MultipartForm(); err == nil && form != nil {
+		if values := form.Value[key]; len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+const (
+	sourceTypeQuery  = "query"
+	sourceTypeForm   = "form"
+	sourceTypeHeader = "header"
+	sourceTypeCookie = "cookie"
+	sourceTypeParam  = "param"
+)
+
 // New creates a middleware for use in Fiber.
-func New(opts *Config) func(*fiber.Ctx) {
+func New(opts *Config) fiber.Handler {
 	config := &Config{
 		BodyKey:    "access_token",
 		HeaderKey:  "Bearer",
 		QueryKey:   "access_token",
+		CookieKey:  "access_token",
 		RequestKey: "token",
 	}
 
@@ -98,57 +286,205 @@ func New(opts *Config) func(*fiber.Ctx) {
 			config.HeaderKey = opts.HeaderKey
 		}
 
+		if len(opts.HeaderKeys) > 0 {
+			config.HeaderKeys = opts.HeaderKeys
+		}
+
+		config.StrictRFC6750 = opts.StrictRFC6750
+
 		if len(opts.QueryKey) > 0 {
 			config.QueryKey = opts.QueryKey
 		}
 
+		if len(opts.CookieKey) > 0 {
+			config.CookieKey = opts.CookieKey
+		}
+
 		if len(opts.RequestKey) > 0 {
 			config.RequestKey = opts.RequestKey
 		}
+
+		if len(opts.Sources) > 0 {
+			config.Sources = opts.Sources
+		}
+
+		config.Filter = opts.Filter
+		config.Validator = opts.Validator
+		config.SuccessHandler = opts.SuccessHandler
+		config.ErrorHandler = opts.ErrorHandler
+		config.TokenType = opts.TokenType
+		config.JWTConfig = opts.JWTConfig
+		config.PASETOConfig = opts.PASETOConfig
+		config.Introspection = opts.Introspection
 	}
 
-	return func(ctx *fiber.Ctx) {
-		var token string
-		var errored bool = false
+	if config.Introspection != nil && config.Introspection.Cache == nil {
+		config.Introspection.Cache = newMemoryIntrospectionCache()
+	}
+
+	if len(config.TokenType) == 0 {
+		config.TokenType = TokenTypeOpaque
+	}
+
+	if config.TokenType == TokenTypeJWT && (config.JWTConfig == nil || len(config.JWTConfig.SigningMethods) == 0) {
+		panic("bearertoken: JWTConfig.SigningMethods must be set when TokenType is TokenTypeJWT")
+	}
 
-		// query parameter
-		queryValue := ctx.Query(config.QueryKey)
+	if len(config.HeaderKeys) == 0 {
+		config.HeaderKeys = []string{config.HeaderKey}
+	}
+
+	if config.SuccessHandler == nil {
+		config.SuccessHandler = defaultSuccessHandler
+	}
+
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = defaultErrorHandler
+	}
+
+	if len(config.Sources) == 0 {
+		config.Sources = []string{
+			sourceTypeQuery + ":" + config.QueryKey,
+			sourceTypeForm + ":" + config.BodyKey,
+			sourceTypeHeader + ":Authorization",
+			sourceTypeCookie + ":" + config.CookieKey,
+		}
+	}
 
-		if len(queryValue) > 0 {
-			token = queryValue
+	return func(c *fiber.Ctx) error {
+		if config.Filter != nil && config.Filter(c) {
+			return c.Next()
 		}
 
-		// body parameter
-		bodyValue := ctx.Body(config.BodyKey)
+		var token string
+		var errored bool = false
+		var badScheme bool = false
+
+		for _, source := range config.Sources {
+			components := strings.SplitN(source, ":", 2)
 
-		if len(bodyValue) > 0 {
-			if len(token) > 0 {
-				errored = true
+			if len(components) != 2 {
+				continue
 			}
 
-			token = bodyValue
-		}
+			sourceType := components[0]
+			sourceKey := components[1]
+
+			var value string
+
+			switch sourceType {
+			case sourceTypeQuery:
+				value = c.Query(sourceKey)
+			case sourceTypeForm:
+				value = formValue(c, sourceKey)
+			case sourceTypeHeader:
+				headerValue := c.Get(sourceKey)
+
+				if len(headerValue) > 0 {
+					headerComponents := strings.SplitN(headerValue, " ", 2)
 
-		// request authorization header
-		headerValue := ctx.Get("authorization")
+					matched := false
 
-		if len(headerValue) > 0 {
-			components := strings.SplitN(headerValue, " ", 2)
+					if len(headerComponents) == 2 && len(headerComponents[0]) > 0 {
+						for _, headerKey := range config.HeaderKeys {
+							if strings.EqualFold(headerComponents[0], headerKey) {
+								matched = true
+								value = headerComponents[1]
+								break
+							}
+						}
+					}
 
-			if len(components) == 2 && components[0] == config.HeaderKey {
+					if !matched && config.StrictRFC6750 {
+						badScheme = true
+					}
+				}
+			case sourceTypeCookie:
+				value = c.Cookies(sourceKey)
+			case sourceTypeParam:
+				value = c.Params(sourceKey)
+			}
+
+			if len(value) > 0 {
 				if len(token) > 0 {
 					errored = true
 				}
 
-				token = components[1]
+				token = value
 			}
 		}
 
-		if errored {
-			ctx.Status(400).Send()
-		} else {
-			ctx.Locals(config.RequestKey, token)
-			ctx.Next()
+		if errored || badScheme {
+			return fiber.NewError(fiber.StatusBadRequest)
 		}
+
+		c.Locals(config.RequestKey, token)
+
+		switch config.TokenType {
+		case TokenTypeJWT:
+			claims, err := verifyJWT(token, config.JWTConfig)
+
+			if err != nil {
+				return config.ErrorHandler(c, err)
+			}
+
+			c.Locals(config.RequestKey+"_claims", claims)
+		case TokenTypePASETO:
+			claims, err := verifyPASETO(token, config.PASETOConfig)
+
+			if err != nil {
+				return config.ErrorHandler(c, err)
+			}
+
+			c.Locals(config.RequestKey+"_claims", claims)
+		}
+
+		if config.Introspection != nil {
+			introspection, err := introspectToken(token, config.Introspection)
+
+			if err != nil {
+				return config.ErrorHandler(c, err)
+			}
+
+			if !introspection.Active || (introspection.Exp > 0 && time.Now().Unix() > introspection.Exp) {
+				return config.ErrorHandler(c, ErrInactiveToken)
+			}
+
+			if !introspectedScopesSatisfy(introspection.Scope, config.Introspection.RequiredScopes) {
+				return config.ErrorHandler(c, ErrInsufficientScope)
+			}
+
+			localsKey := config.Introspection.LocalsKey
+
+			if len(localsKey) == 0 {
+				localsKey = config.RequestKey + "_introspection"
+			}
+
+			c.Locals(localsKey, introspection)
+		}
+
+		if config.Validator == nil {
+			if config.TokenType != TokenTypeOpaque || config.Introspection != nil {
+				return config.SuccessHandler(c)
+			}
+
+			return c.Next()
+		}
+
+		if len(token) == 0 {
+			return config.ErrorHandler(c, ErrMissingOrMalformedToken)
+		}
+
+		ok, err := config.Validator(c, token)
+
+		if err != nil {
+			return config.ErrorHandler(c, err)
+		}
+
+		if !ok {
+			return config.ErrorHandler(c, ErrMissingOrMalformedToken)
+		}
+
+		return config.SuccessHandler(c)
 	}
 }
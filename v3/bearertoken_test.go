@@ -0,0 +1,609 @@
+package bearertoken
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v3"
+	paseto "github.com/o1egl/paseto"
+)
+
+func firstTestCase(app *fiber.App) string {
+	// It should not return a token if none is provided
+	req, _ := http.NewRequest("GET", "http://localhost:8000", nil)
+	res, _ := app.Test(req)
+
+	body, _ := ioutil.ReadAll(res.Body)
+	defer res.Body.Close()
+	return string(body)
+}
+
+func secondTestCase(app *fiber.App, key string) string {
+	// It should return token from header
+
+	if len(key) == 0 {
+		key = "Bearer"
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost:8000", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("%s nanachi-cute", key))
+	res, _ := app.Test(req)
+
+	body, _ := ioutil.ReadAll(res.Body)
+	defer res.Body.Close()
+	return string(body)
+}
+
+func thirdTestCase(app *fiber.App, key string) string {
+	// It should return token from param
+
+	if len(key) == 0 {
+		key = "access_token"
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:8000/?%s=nanachi-cute", key), nil)
+	res, _ := app.Test(req)
+
+	body, _ := ioutil.ReadAll(res.Body)
+	defer res.Body.Close()
+	return string(body)
+}
+
+func fourthTestCase(app *fiber.App, key string) string {
+	// It should return token from body
+
+	if len(key) == 0 {
+		key = "access_token"
+	}
+
+	payload := []byte(fmt.Sprintf("%s=nanachi-cute", key))
+
+	req, _ := http.NewRequest("POST", "http://localhost:8000", bytes.NewBuffer(payload))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+
+	res, _ := app.Test(req)
+
+	body, _ := ioutil.ReadAll(res.Body)
+	defer res.Body.Close()
+	return string(body)
+}
+
+func sixthTestCase(app *fiber.App, key string) string {
+	// It should return token from cookie
+
+	if len(key) == 0 {
+		key = "access_token"
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost:8000", nil)
+	req.AddCookie(&http.Cookie{Name: key, Value: "nanachi-cute"})
+	res, _ := app.Test(req)
+
+	body, _ := ioutil.ReadAll(res.Body)
+	defer res.Body.Close()
+	return string(body)
+}
+
+func fifthTestCase(app *fiber.App, queryKey, headerKey string) int {
+	// It should return HTTP status 400 if token was provided multiple times
+
+	if len(queryKey) == 0 {
+		queryKey = "access_token"
+	}
+
+	if len(headerKey) == 0 {
+		headerKey = "Bearer"
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:8000/?%s=nanachi-cute", queryKey), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("%s nanachi-cute", headerKey))
+
+	res, _ := app.Test(req)
+	return res.StatusCode
+}
+
+func TestMiddleware(t *testing.T) {
+	testCases := []Config{
+		Config{},
+		Config{
+			BodyKey:    "custom_token",
+			HeaderKey:  "custom_token",
+			QueryKey:   "custom_token",
+			CookieKey:  "custom_token",
+			RequestKey: "bearer",
+		},
+	}
+	testCaseLabels := []string{
+		"with default settings",
+		"with custom settings",
+	}
+
+	for idx, config := range testCases {
+		t.Run(testCaseLabels[idx], func(t *testing.T) {
+			app := fiber.New()
+
+			app.Use(New(&config))
+
+			var requestKey string = "token"
+			if len(config.RequestKey) > 0 {
+				requestKey = config.RequestKey
+			}
+
+			app.Get("/", func(c fiber.Ctx) error {
+				return c.SendString(fmt.Sprintf("%v", c.Locals(requestKey)))
+			})
+
+			app.Post("/", func(c fiber.Ctx) error {
+				return c.SendString(fmt.Sprintf("%v", c.Locals(requestKey)))
+			})
+
+			first := firstTestCase(app)
+			if first != "" {
+				t.Errorf(`expected: <empty string>, got: "%s"`, first)
+			}
+
+			second := secondTestCase(app, config.HeaderKey)
+			if second != "nanachi-cute" {
+				t.Errorf(`expected: "nanachi-cute", got: "%s"`, second)
+			}
+
+			third := thirdTestCase(app, config.QueryKey)
+			if third != "nanachi-cute" {
+				t.Errorf(`expected: "nanachi-cute", got: "%s"`, third)
+			}
+
+			fourth := fourthTestCase(app, config.BodyKey)
+			if fourth != "nanachi-cute" {
+				t.Errorf(`expected: "nanachi-cute", got: "%s"`, fourth)
+			}
+
+			fifth := fifthTestCase(app, config.QueryKey, config.HeaderKey)
+			if fifth != 400 {
+				t.Errorf(`expected: 400, got: %d`, fifth)
+			}
+
+			sixth := sixthTestCase(app, config.CookieKey)
+			if sixth != "nanachi-cute" {
+				t.Errorf(`expected: "nanachi-cute", got: "%s"`, sixth)
+			}
+		})
+	}
+}
+
+func TestValidator(t *testing.T) {
+	app := fiber.New()
+
+	app.Use(New(&Config{
+		Validator: func(c fiber.Ctx, token string) (bool, error) {
+			return token == "nanachi-cute", nil
+		},
+	}))
+
+	app.Get("/", func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	t.Run("it should call SuccessHandler on a valid token", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token=nanachi-cute", nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 200 {
+			t.Errorf("expected: 200, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should call ErrorHandler with 401 on a missing token", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "http://localhost:8000", nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should call ErrorHandler with 401 on an invalid token", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token=wrong", nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+}
+
+func TestFilter(t *testing.T) {
+	app := fiber.New()
+
+	app.Use(New(&Config{
+		Filter: func(c fiber.Ctx) bool {
+			return c.Path() == "/health"
+		},
+		Validator: func(c fiber.Ctx, token string) (bool, error) {
+			return false, nil
+		},
+	}))
+
+	app.Get("/health", func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:8000/health", nil)
+	res, _ := app.Test(req)
+
+	if res.StatusCode != 200 {
+		t.Errorf("expected: 200, got: %d", res.StatusCode)
+	}
+}
+
+func TestSchemeMatching(t *testing.T) {
+	t.Run("it should match the scheme case-insensitively", func(t *testing.T) {
+		app := fiber.New()
+		app.Use(New(nil))
+		app.Get("/", func(c fiber.Ctx) error {
+			return c.SendString(fmt.Sprintf("%v", c.Locals("token")))
+		})
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000", nil)
+		req.Header.Add("Authorization", "bearer nanachi-cute")
+		res, _ := app.Test(req)
+
+		body, _ := ioutil.ReadAll(res.Body)
+		defer res.Body.Close()
+
+		if string(body) != "nanachi-cute" {
+			t.Errorf(`expected: "nanachi-cute", got: "%s"`, string(body))
+		}
+	})
+
+	t.Run("it should accept any scheme listed in HeaderKeys", func(t *testing.T) {
+		app := fiber.New()
+		app.Use(New(&Config{
+			HeaderKeys: []string{"Bearer", "Token"},
+		}))
+		app.Get("/", func(c fiber.Ctx) error {
+			return c.SendString(fmt.Sprintf("%v", c.Locals("token")))
+		})
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000", nil)
+		req.Header.Add("Authorization", "Token nanachi-cute")
+		res, _ := app.Test(req)
+
+		body, _ := ioutil.ReadAll(res.Body)
+		defer res.Body.Close()
+
+		if string(body) != "nanachi-cute" {
+			t.Errorf(`expected: "nanachi-cute", got: "%s"`, string(body))
+		}
+	})
+
+	t.Run("it should reject an unrecognized scheme with 400 when StrictRFC6750 is set", func(t *testing.T) {
+		app := fiber.New()
+		app.Use(New(&Config{
+			StrictRFC6750: true,
+		}))
+		app.Get("/", func(c fiber.Ctx) error {
+			return c.SendString(fmt.Sprintf("%v", c.Locals("token")))
+		})
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000", nil)
+		req.Header.Add("Authorization", "Basic nanachi-cute")
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 400 {
+			t.Errorf("expected: 400, got: %d", res.StatusCode)
+		}
+	})
+}
+
+
+func TestIntrospection(t *testing.T) {
+	var hits int32
+
+	introspectionServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		r.ParseForm()
+
+		switch r.FormValue("token") {
+		case "nanachi-cute":
+			w.Write([]byte(`{"active": true, "scope": "read:things", "sub": "nanachi"}`))
+		case "expired":
+			w.Write([]byte(`{"active": true, "scope": "read:things", "sub": "nanachi", "exp": 1}`))
+		case "wrong-scope":
+			w.Write([]byte(`{"active": true, "scope": "read:other", "sub": "nanachi"}`))
+		default:
+			w.Write([]byte(`{"active": false}`))
+		}
+	}))
+	defer introspectionServer.Close()
+
+	app := fiber.New()
+
+	app.Use(New(&Config{
+		Introspection: &IntrospectionConfig{
+			URL:            introspectionServer.URL,
+			RequiredScopes: []string{"read:things"},
+		},
+	}))
+
+	app.Get("/", func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	t.Run("it should allow an active token with the required scope", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token=nanachi-cute", nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 200 {
+			t.Errorf("expected: 200, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should reject an inactive token with 401", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token=not-a-token", nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should reject an expired token with 401", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token=expired", nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should reject a token missing a required scope with 401", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token=wrong-scope", nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should cache introspection responses and skip a second round-trip", func(t *testing.T) {
+		before := atomic.LoadInt32(&hits)
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token=nanachi-cute", nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 200 {
+			t.Errorf("expected: 200, got: %d", res.StatusCode)
+		}
+
+		after := atomic.LoadInt32(&hits)
+		if after != before {
+			t.Errorf("expected a cache hit to skip the introspection round-trip, but hits went from %d to %d", before, after)
+		}
+	})
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+
+	if err != nil {
+		t.Fatalf("failed to sign JWT: %v", err)
+	}
+
+	return token
+}
+
+func TestJWT(t *testing.T) {
+	signingKey := []byte("it's-a-secret-to-everybody")
+
+	app := fiber.New()
+
+	app.Use(New(&Config{
+		TokenType: TokenTypeJWT,
+		JWTConfig: &JWTConfig{
+			SigningKey:     signingKey,
+			SigningMethods: []string{"HS256"},
+			Issuer:         "nanachi",
+			Audience:       "made-in-abyss",
+			ClockSkew:      time.Minute,
+		},
+	}))
+
+	app.Get("/", func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	t.Run("it should allow a validly signed token with matching claims", func(t *testing.T) {
+		token := signJWT(t, jwt.SigningMethodHS256, signingKey, jwt.MapClaims{
+			"iss": "nanachi",
+			"aud": "made-in-abyss",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 200 {
+			t.Errorf("expected: 200, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should reject a token signed with a disallowed algorithm", func(t *testing.T) {
+		token := signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwt.MapClaims{
+			"iss": "nanachi",
+			"aud": "made-in-abyss",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should reject a token with the wrong issuer", func(t *testing.T) {
+		token := signJWT(t, jwt.SigningMethodHS256, signingKey, jwt.MapClaims{
+			"iss": "riko",
+			"aud": "made-in-abyss",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should reject an expired token", func(t *testing.T) {
+		token := signJWT(t, jwt.SigningMethodHS256, signingKey, jwt.MapClaims{
+			"iss": "nanachi",
+			"aud": "made-in-abyss",
+			"exp": time.Now().Add(-time.Hour).Unix(),
+		})
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should accept a recently expired token within the allowed clock skew", func(t *testing.T) {
+		token := signJWT(t, jwt.SigningMethodHS256, signingKey, jwt.MapClaims{
+			"iss": "nanachi",
+			"aud": "made-in-abyss",
+			"exp": time.Now().Add(-30 * time.Second).Unix(),
+		})
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 200 {
+			t.Errorf("expected: 200, got: %d", res.StatusCode)
+		}
+	})
+}
+
+func TestPASETO(t *testing.T) {
+	symmetricKey := []byte("YELLOW SUBMARINE, BLACK WIZARDRY")
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key pair: %v", err)
+	}
+
+	app := fiber.New()
+
+	app.Use(New(&Config{
+		TokenType: TokenTypePASETO,
+		PASETOConfig: &PASETOConfig{
+			KeyFunc: func(token string) (interface{}, error) {
+				if strings.HasPrefix(token, "v2.public.") {
+					return publicKey, nil
+				}
+
+				return symmetricKey, nil
+			},
+			Issuer:   "nanachi",
+			Audience: "made-in-abyss",
+		},
+	}))
+
+	app.Get("/", func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	t.Run("it should allow a valid v2.local token", func(t *testing.T) {
+		token, err := paseto.NewV2().Encrypt(symmetricKey, map[string]interface{}{
+			"iss": "nanachi",
+			"aud": "made-in-abyss",
+		}, nil)
+
+		if err != nil {
+			t.Fatalf("failed to encrypt PASETO token: %v", err)
+		}
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 200 {
+			t.Errorf("expected: 200, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should allow a valid v2.public token", func(t *testing.T) {
+		token, err := paseto.NewV2().Sign(privateKey, map[string]interface{}{
+			"iss": "nanachi",
+			"aud": "made-in-abyss",
+		}, nil)
+
+		if err != nil {
+			t.Fatalf("failed to sign PASETO token: %v", err)
+		}
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 200 {
+			t.Errorf("expected: 200, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should reject a v2.public token verified against the wrong public key", func(t *testing.T) {
+		_, otherPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+
+		if err != nil {
+			t.Fatalf("failed to generate ed25519 key pair: %v", err)
+		}
+
+		token, err := paseto.NewV2().Sign(otherPrivateKey, map[string]interface{}{
+			"iss": "nanachi",
+			"aud": "made-in-abyss",
+		}, nil)
+
+		if err != nil {
+			t.Fatalf("failed to sign PASETO token: %v", err)
+		}
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it should reject a token with the wrong audience", func(t *testing.T) {
+		token, err := paseto.NewV2().Encrypt(symmetricKey, map[string]interface{}{
+			"iss": "nanachi",
+			"aud": "abyss-survey-team",
+		}, nil)
+
+		if err != nil {
+			t.Fatalf("failed to encrypt PASETO token: %v", err)
+		}
+
+		req, _ := http.NewRequest("GET", "http://localhost:8000/?access_token="+token, nil)
+		res, _ := app.Test(req)
+
+		if res.StatusCode != 401 {
+			t.Errorf("expected: 401, got: %d", res.StatusCode)
+		}
+	})
+}
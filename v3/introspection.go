@@ -0,0 +1,212 @@
+package bearertoken
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInactiveToken is returned when the introspection endpoint reports the token as
+// inactive, or when the token has already expired.
+var ErrInactiveToken = errors.New("token is inactive or expired")
+
+// ErrInsufficientScope is returned when the introspected token does not carry all of the
+// scopes listed in IntrospectionConfig.RequiredScopes.
+var ErrInsufficientScope = errors.New("token does not have the required scope")
+
+// IntrospectionResponse holds the fields of an RFC7662 token introspection response that
+// this middleware cares about.
+type IntrospectionResponse struct {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope"`
+	Sub      string `json:"sub"`
+	Exp      int64  `json:"exp"`
+	ClientID string `json:"client_id,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// IntrospectionCache caches introspection responses so that every request doesn't have to
+// round-trip to the introspection endpoint. Implementations must key entries on the hash
+// passed to Get/Set, never on the raw token.
+type IntrospectionCache interface {
+	Get(hash string) (*IntrospectionResponse, bool)
+	Set(hash string, resp *IntrospectionResponse, ttl time.Duration)
+}
+
+type memoryCacheEntry struct {
+	response  *IntrospectionResponse
+	expiresAt time.Time
+}
+
+type memoryIntrospectionCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+// newMemoryIntrospectionCache creates the default in-memory IntrospectionCache used when
+// IntrospectionConfig.Cache is not set.
+func newMemoryIntrospectionCache() *memoryIntrospectionCache {
+	return &memoryIntrospectionCache{
+		entries: make(map[string]memoryCacheEntry),
+	}
+}
+
+func (c *memoryIntrospectionCache) Get(hash string) (*IntrospectionResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[hash]
+
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, hash)
+		return nil, false
+	}
+
+	return entry.response, true
+}
+
+func (c *memoryIntrospectionCache) Set(hash string, resp *IntrospectionResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[hash] = memoryCacheEntry{
+		response:  resp,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
+
+// IntrospectionConfig holds the settings used to validate the bearer token against a
+// remote RFC7662 token introspection endpoint.
+type IntrospectionConfig struct {
+	// URL is the token introspection endpoint.
+	URL string
+
+	// ClientID and ClientSecret authenticate the introspection request using HTTP
+	// Basic auth, per RFC7662. Ignored if AuthHeader is set.
+	ClientID     string
+	ClientSecret string
+
+	// AuthHeader, if set, is called with the outgoing introspection request so that
+	// the caller can set custom authentication headers instead of ClientID/
+	// ClientSecret.
+	// Optional.
+	AuthHeader func(*http.Request)
+
+	// HTTPClient is used to perform the introspection request.
+	// Optional. Default: http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Cache stores introspection responses, keyed by a SHA-256 hash of the token, so
+	// that the endpoint isn't hit on every request. A response is cached for five
+	// minutes, or only until its "exp" claim if that comes sooner.
+	// Optional. Default: an in-memory cache.
+	Cache IntrospectionCache
+
+	// RequiredScopes lists the scopes that the introspected token must carry, all of
+	// them, for the request to be allowed through.
+	// Optional. Default: none required.
+	RequiredScopes []string
+
+	// LocalsKey defines the name of the local variable that will hold the
+	// *IntrospectionResponse.
+	// Optional. Default: RequestKey+"_introspection".
+	LocalsKey string
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func introspectToken(token string, config *IntrospectionConfig) (*IntrospectionResponse, error) {
+	hash := hashToken(token)
+
+	if config.Cache != nil {
+		if cached, ok := config.Cache.Get(hash); ok {
+			return cached, nil
+		}
+	}
+
+	form := url.Values{}
+	form.Set("token", token)
+
+	req, err := http.NewRequest("POST", config.URL, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if config.AuthHeader != nil {
+		config.AuthHeader(req)
+	} else if len(config.ClientID) > 0 {
+		req.SetBasicAuth(config.ClientID, config.ClientSecret)
+	}
+
+	client := config.HTTPClient
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	var introspection IntrospectionResponse
+
+	if err := json.NewDecoder(res.Body).Decode(&introspection); err != nil {
+		return nil, err
+	}
+
+	if config.Cache != nil {
+		ttl := 5 * time.Minute
+
+		if introspection.Exp > 0 {
+			if untilExp := time.Until(time.Unix(introspection.Exp, 0)); untilExp < ttl {
+				ttl = untilExp
+			}
+		}
+
+		if ttl > 0 {
+			config.Cache.Set(hash, &introspection, ttl)
+		}
+	}
+
+	return &introspection, nil
+}
+
+func introspectedScopesSatisfy(scope string, required []string) bool {
+	if len(required) == 0 {
+		return true
+	}
+
+	granted := make(map[string]bool)
+
+	for _, s := range strings.Fields(scope) {
+		granted[s] = true
+	}
+
+	for _, r := range required {
+		if !granted[r] {
+			return false
+		}
+	}
+
+	return true
+}
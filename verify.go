@@ -0,0 +1,235 @@
+package bearertoken
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	paseto "github.com/o1egl/paseto"
+)
+
+// TokenType selects how the extracted bearer token is verified before it is handed off to
+// the Validator (if any) and the route handler.
+type TokenType string
+
+const (
+	// TokenTypeOpaque treats the token as an opaque string. This is the default, and
+	// matches the middleware's original behavior.
+	TokenTypeOpaque TokenType = "opaque"
+
+	// TokenTypeJWT parses and verifies the token as a JSON Web Token, using JWTConfig.
+	TokenTypeJWT TokenType = "jwt"
+
+	// TokenTypePASETO parses and verifies the token as a PASETO token, using
+	// PASETOConfig.
+	TokenTypePASETO TokenType = "paseto"
+)
+
+// ErrInvalidToken is returned when a JWT or PASETO token fails signature or claim
+// verification.
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWTConfig holds the settings used to verify the bearer token when Config.TokenType is
+// TokenTypeJWT.
+type JWTConfig struct {
+	// SigningKey is the key (or public key) used to verify the token's signature.
+	// Ignored if KeyFunc is set.
+	SigningKey interface{}
+
+	// KeyFunc is called with the parsed (but not yet verified) token and returns the
+	// key to use for signature verification. Use this instead of SigningKey to
+	// support JWKS-backed rotating keys.
+	// Optional. Default: always use SigningKey.
+	KeyFunc func(*jwt.Token) (interface{}, error)
+
+	// SigningMethods restricts which JWT "alg" values are accepted. It is required:
+	// New() panics if TokenType is TokenTypeJWT and SigningMethods is empty, since
+	// trusting the token's own "alg" header is the classic algorithm-confusion
+	// footgun.
+	SigningMethods []string
+
+	// Issuer, if set, is compared against the token's "iss" claim.
+	Issuer string
+
+	// Audience, if set, is compared against the token's "aud" claim.
+	Audience string
+
+	// ClockSkew is the allowed leeway when validating the "exp", "nbf", and "iat"
+	// claims.
+	// Optional. Default: 0.
+	ClockSkew time.Duration
+}
+
+// PASETOConfig holds the settings used to verify the bearer token when Config.TokenType
+// is TokenTypePASETO.
+type PASETOConfig struct {
+	// KeyFunc returns the key used to verify the token. For a "v2.local." token
+	// (symmetric encryption), it must return a []byte key. For a "v2.public." token
+	// (Ed25519 signature), it must return an ed25519.PublicKey.
+	KeyFunc func(token string) (interface{}, error)
+
+	// Issuer, if set, is compared against the token's "iss" claim.
+	Issuer string
+
+	// Audience, if set, is compared against the token's "aud" claim.
+	Audience string
+
+	// ClockSkew is the allowed leeway when validating the "exp", "nbf", and "iat"
+	// claims.
+	// Optional. Default: 0.
+	ClockSkew time.Duration
+}
+
+func verifyJWTClaims(claims jwt.MapClaims, config *JWTConfig) error {
+	now := time.Now()
+
+	if len(config.Issuer) > 0 && !claims.VerifyIssuer(config.Issuer, true) {
+		return ErrInvalidToken
+	}
+
+	if len(config.Audience) > 0 && !claims.VerifyAudience(config.Audience, true) {
+		return ErrInvalidToken
+	}
+
+	if !claims.VerifyExpiresAt(now.Add(-config.ClockSkew).Unix(), false) {
+		return ErrInvalidToken
+	}
+
+	if !claims.VerifyNotBefore(now.Add(config.ClockSkew).Unix(), false) {
+		return ErrInvalidToken
+	}
+
+	if !claims.VerifyIssuedAt(now.Add(config.ClockSkew).Unix(), false) {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func verifyJWT(token string, config *JWTConfig) (jwt.MapClaims, error) {
+	// Time-based claims are checked by verifyJWTClaims, which applies ClockSkew.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	parsed, err := parser.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if len(config.SigningMethods) > 0 {
+			allowed := false
+
+			for _, method := range config.SigningMethods {
+				if method == t.Method.Alg() {
+					allowed = true
+					break
+				}
+			}
+
+			if !allowed {
+				return nil, ErrInvalidToken
+			}
+		}
+
+		if config.KeyFunc != nil {
+			return config.KeyFunc(t)
+		}
+
+		return config.SigningKey, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+
+	if !ok || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	if err := verifyJWTClaims(claims, config); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func verifyPASETOClaims(claims map[string]interface{}, config *PASETOConfig) error {
+	now := time.Now()
+
+	if len(config.Issuer) > 0 {
+		if iss, ok := claims["iss"].(string); !ok || iss != config.Issuer {
+			return ErrInvalidToken
+		}
+	}
+
+	if len(config.Audience) > 0 {
+		if aud, ok := claims["aud"].(string); !ok || aud != config.Audience {
+			return ErrInvalidToken
+		}
+	}
+
+	if exp, ok := claims["exp"].(string); ok {
+		expiresAt, err := time.Parse(time.RFC3339, exp)
+
+		if err != nil || now.Add(-config.ClockSkew).After(expiresAt) {
+			return ErrInvalidToken
+		}
+	}
+
+	for _, claim := range []string{"nbf", "iat"} {
+		if value, ok := claims[claim].(string); ok {
+			notBefore, err := time.Parse(time.RFC3339, value)
+
+			if err != nil || now.Add(config.ClockSkew).Before(notBefore) {
+				return ErrInvalidToken
+			}
+		}
+	}
+
+	return nil
+}
+
+func verifyPASETO(token string, config *PASETOConfig) (map[string]interface{}, error) {
+	if config.KeyFunc == nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := config.KeyFunc(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	var footer string
+
+	switch {
+	case strings.HasPrefix(token, "v2.local."):
+		symmetricKey, ok := key.([]byte)
+
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+
+		if err := paseto.NewV2().Decrypt(token, symmetricKey, &claims, &footer); err != nil {
+			return nil, ErrInvalidToken
+		}
+	case strings.HasPrefix(token, "v2.public."):
+		publicKey, ok := key.(ed25519.PublicKey)
+
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+
+		if err := paseto.NewV2().Verify(token, publicKey, &claims, &footer); err != nil {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	if err := verifyPASETOClaims(claims, config); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}